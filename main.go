@@ -2,14 +2,22 @@ package main
 
 import (
 	"crypto/sha256"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
 	"log"
+	"math/rand"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -20,41 +28,307 @@ type Config struct {
 	StorageDir string
 	UpstreamURL string
 	CacheSize  int64 // MB
+	RevalidateTTL time.Duration
+	MaxRetries int
+	ParallelThreshold int64
+	ParallelChunks int
+	MaxParallelConnections int
+	StaleTempAge time.Duration
+	ReaperInterval time.Duration
+}
+
+// maxConcurrencyOverride bounds the ?concurrency= query parameter so a
+// client can't force an unreasonable number of upstream connections.
+const maxConcurrencyOverride = 16
+
+// retryBaseDelay and retryMaxDelay bound the exponential backoff (with full
+// jitter) between upstream fetch retries.
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+)
+
+// contentLengthPending marks a downloadGroup whose upstream response headers
+// haven't arrived yet, as distinct from an upstream that reports no length at all.
+const contentLengthPending int64 = -2
+
+const downloadChunkSize = 32 * 1024
+
+// subscriberWriteTimeout bounds how long a single chunk write to a lagging
+// client may take before we give up on it; it never blocks the leader.
+const subscriberWriteTimeout = 10 * time.Second
+
+// downloadGroup is the single in-flight download for a given local path.
+// One goroutine (the leader, started by the first request to see a cache
+// miss) fetches from upstream and writes to tempPath while updating
+// written/contentLength/err under mu and broadcasting on cond. Every
+// request for the path -- including the leader's own -- reads back the
+// result by tailing tempPath through serveFromGroup, so a slow or dropped
+// subscriber never blocks the download itself.
+type downloadGroup struct {
+	mu            sync.Mutex
+	cond          *sync.Cond
+	written       int64
+	contentLength int64
+	err           error
+	finished      bool
+	tempPath      string
 }
 
 type FileDownloader struct {
-	locks sync.Map // path -> *sync.Mutex
+	groups sync.Map // path -> *downloadGroup
+}
+
+// cacheLowWaterRatio is the fraction of the configured cache limit we evict
+// down to, so a single eviction pass doesn't immediately trigger another.
+const cacheLowWaterRatio = 0.9
+
+type cacheEntry struct {
+	size       int64
+	lastAccess time.Time
+	tag        string
+}
+
+// CacheManager tracks every cached file under StorageDir and enforces
+// config.CacheSize with LRU eviction once usage exceeds the limit.
+type CacheManager struct {
+	mu         sync.Mutex
+	entries    map[string]*cacheEntry
+	totalSize  int64
+	limitBytes int64
+	evictions  int64
+	hits       int64
+	misses     int64
+	attempts   int64
+}
+
+func newCacheManager(limitMB int64) *CacheManager {
+	return &CacheManager{
+		entries:    make(map[string]*cacheEntry),
+		limitBytes: limitMB * 1024 * 1024,
+	}
+}
+
+// rebuildIndex walks storageDir to repopulate the in-memory index on
+// startup. Leftover *.tmp.* files from crashed downloads are swept rather
+// than indexed; *.sha256 sidecars are skipped since they aren't served
+// directly.
+func (cm *CacheManager) rebuildIndex(storageDir string) error {
+	return filepath.Walk(storageDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if isTempFile(path) {
+			if rmErr := os.Remove(path); rmErr != nil {
+				log.Printf("Warning: failed to sweep stale temp file %s: %v", path, rmErr)
+			} else {
+				log.Printf("Swept stale temp file: %s", path)
+			}
+			return nil
+		}
+		if strings.HasSuffix(path, ".sha256") || strings.HasSuffix(path, ".meta") {
+			return nil
+		}
+
+		cm.mu.Lock()
+		cm.entries[path] = &cacheEntry{size: info.Size(), lastAccess: info.ModTime()}
+		cm.totalSize += info.Size()
+		cm.mu.Unlock()
+		return nil
+	})
+}
+
+func isTempFile(path string) bool {
+	return strings.Contains(filepath.Base(path), ".tmp.")
+}
+
+// recordAccess bumps the LRU clock for an already-indexed file, e.g. on a
+// cache hit serve.
+func (cm *CacheManager) recordAccess(path string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if e, ok := cm.entries[path]; ok {
+		e.lastAccess = time.Now()
+	}
+}
+
+// registerDownload indexes a newly completed download and evicts older
+// entries if that pushes total usage over the limit. tag is the optional
+// ?tag= value the request was fetched with, for later bulk purge by tag; it
+// does not survive a restart since rebuildIndex has no way to recover it.
+func (cm *CacheManager) registerDownload(path string, size int64, tag string) {
+	cm.mu.Lock()
+	if old, ok := cm.entries[path]; ok {
+		cm.totalSize -= old.size
+	}
+	cm.entries[path] = &cacheEntry{size: size, lastAccess: time.Now(), tag: tag}
+	cm.totalSize += size
+	cm.mu.Unlock()
+
+	cm.evictIfNeeded()
+}
+
+// evictIfNeeded evicts least-recently-used entries until total usage is back
+// at or below the low-water mark.
+func (cm *CacheManager) evictIfNeeded() {
+	cm.mu.Lock()
+	if cm.limitBytes <= 0 || cm.totalSize <= cm.limitBytes {
+		cm.mu.Unlock()
+		return
+	}
+
+	lowWater := int64(float64(cm.limitBytes) * cacheLowWaterRatio)
+	paths := make([]string, 0, len(cm.entries))
+	for p := range cm.entries {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return cm.entries[paths[i]].lastAccess.Before(cm.entries[paths[j]].lastAccess)
+	})
+
+	var evicted []string
+	for _, p := range paths {
+		if cm.totalSize <= lowWater {
+			break
+		}
+		cm.totalSize -= cm.entries[p].size
+		delete(cm.entries, p)
+		evicted = append(evicted, p)
+	}
+	cm.evictions += int64(len(evicted))
+	cm.mu.Unlock()
+
+	for _, p := range evicted {
+		os.Remove(p)
+		os.Remove(p + ".sha256")
+		os.Remove(p + ".meta")
+		log.Printf("Evicted from cache: %s", p)
+	}
+}
+
+// hasPathPrefix reports whether path is prefix itself, or lies under it as a
+// directory. A plain strings.HasPrefix would also match a sibling whose name
+// happens to extend prefix (e.g. "/ubuntu" matching "/ubuntu-25.10/..."),
+// which isn't what a path-prefix purge means.
+func hasPathPrefix(path, prefix string) bool {
+	if path == prefix {
+		return true
+	}
+	return strings.HasPrefix(path, prefix+string(filepath.Separator))
+}
+
+// Purge force-drops every indexed entry whose local path is prefix (or lies
+// under it as a directory) or whose tag equals tag, returning the number of
+// files and bytes removed. An empty prefix or tag is not matched against, so
+// callers needing only one should pass "" for the other.
+func (cm *CacheManager) Purge(prefix, tag string) (int, int64) {
+	cm.mu.Lock()
+	var removed []string
+	var bytes int64
+	for p, e := range cm.entries {
+		if (prefix != "" && hasPathPrefix(p, prefix)) || (tag != "" && e.tag == tag) {
+			removed = append(removed, p)
+			bytes += e.size
+			cm.totalSize -= e.size
+		}
+	}
+	for _, p := range removed {
+		delete(cm.entries, p)
+	}
+	cm.mu.Unlock()
+
+	for _, p := range removed {
+		os.Remove(p)
+		os.Remove(p + ".sha256")
+		os.Remove(p + ".meta")
+	}
+	return len(removed), bytes
+}
+
+func (cm *CacheManager) hit()               { atomic.AddInt64(&cm.hits, 1) }
+func (cm *CacheManager) miss()              { atomic.AddInt64(&cm.misses, 1) }
+func (cm *CacheManager) recordAttempts(n int64) { atomic.AddInt64(&cm.attempts, n) }
+
+// CacheStats is a snapshot of cache usage and activity for /api/stats.
+type CacheStats struct {
+	Files      int
+	UsageBytes int64
+	LimitBytes int64
+	Evictions  int64
+	Hits       int64
+	Misses     int64
+	Attempts   int64
+}
+
+func (cm *CacheManager) Stats() CacheStats {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return CacheStats{
+		Files:      len(cm.entries),
+		UsageBytes: cm.totalSize,
+		LimitBytes: cm.limitBytes,
+		Evictions:  cm.evictions,
+		Hits:       atomic.LoadInt64(&cm.hits),
+		Misses:     atomic.LoadInt64(&cm.misses),
+		Attempts:   atomic.LoadInt64(&cm.attempts),
+	}
 }
 
 var config Config
 var downloader = &FileDownloader{}
+var cacheManager *CacheManager
+
+// upstreamSemaphore caps the total number of in-flight upstream connections
+// across all downloads, single-stream or parallel. Sized from
+// config.MaxParallelConnections once flags are parsed.
+var upstreamSemaphore chan struct{}
+
+func acquireUpstreamSlot() { upstreamSemaphore <- struct{}{} }
+func releaseUpstreamSlot() { <-upstreamSemaphore }
 
 func main() {
 	flag.StringVar(&config.Port, "port", "8080", "Server port")
 	flag.StringVar(&config.StorageDir, "storage", "./storage", "Storage directory")
 	flag.StringVar(&config.UpstreamURL, "upstream", "https://mirrors.tuna.tsinghua.edu.cn", "Upstream mirror URL")
 	flag.Int64Var(&config.CacheSize, "cache-size", 10240, "Cache size in MB")
+	flag.DurationVar(&config.RevalidateTTL, "revalidate-ttl", time.Hour, "How long a cached file is served before revalidating against upstream (0 disables revalidation)")
+	flag.IntVar(&config.MaxRetries, "max-retries", 5, "Max attempts for an upstream fetch before giving up")
+	flag.Int64Var(&config.ParallelThreshold, "parallel-threshold", 32*1024*1024, "Minimum file size in bytes to use parallel chunked downloads")
+	flag.IntVar(&config.ParallelChunks, "parallel-chunks", 4, "Number of concurrent connections for a parallel download")
+	flag.IntVar(&config.MaxParallelConnections, "max-parallel-connections", 16, "Global cap on concurrent upstream connections")
+	flag.DurationVar(&config.StaleTempAge, "stale-temp-age", time.Hour, "Age after which an orphaned *.tmp.* file is reaped")
+	flag.DurationVar(&config.ReaperInterval, "reaper-interval", 10*time.Minute, "How often to scan for stale temp files")
 	flag.Parse()
 
+	upstreamSemaphore = make(chan struct{}, config.MaxParallelConnections)
+
 	if err := os.MkdirAll(config.StorageDir, 0755); err != nil {
 		log.Fatalf("Failed to create storage directory: %v", err)
 	}
 
+	cacheManager = newCacheManager(config.CacheSize)
+	if err := cacheManager.rebuildIndex(config.StorageDir); err != nil {
+		log.Printf("Warning: failed to rebuild cache index: %v", err)
+	}
+
+	startTempFileReaper(config.StorageDir, config.StaleTempAge, config.ReaperInterval)
+
 	r := gin.Default()
-	
+
 	// API routes with prefix to avoid conflicts
 	api := r.Group("/api")
 	{
 		api.GET("/health", healthCheck)
 		api.GET("/stats", getStats)
+		api.POST("/purge", purgeHandler)
 	}
-	
+
 	// All other routes go to file proxy
 	r.NoRoute(proxyHandler)
-	
+
 	log.Printf("Starting odSync on port %s", config.Port)
 	log.Printf("Storage: %s, Upstream: %s", config.StorageDir, config.UpstreamURL)
-	
+
 	if err := r.Run(":" + config.Port); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
@@ -68,116 +342,325 @@ func healthCheck(c *gin.Context) {
 }
 
 func getStats(c *gin.Context) {
-	var totalSize int64
-	var fileCount int
-	
-	filepath.Walk(config.StorageDir, func(path string, info os.FileInfo, err error) error {
-		if err == nil && !info.IsDir() {
-			totalSize += info.Size()
-			fileCount++
-		}
-		return nil
-	})
-	
+	stats := cacheManager.Stats()
+
 	c.JSON(http.StatusOK, gin.H{
-		"cached_files": fileCount,
-		"cache_size_mb": totalSize / 1024 / 1024,
+		"cached_files": stats.Files,
+		"cache_size_mb": stats.UsageBytes / 1024 / 1024,
 		"cache_limit_mb": config.CacheSize,
+		"cache_evictions": stats.Evictions,
+		"cache_hits": stats.Hits,
+		"cache_misses": stats.Misses,
+		"download_attempts": stats.Attempts,
 		"storage_dir": config.StorageDir,
 		"upstream": config.UpstreamURL,
 	})
 }
 
-func (fd *FileDownloader) getFileLock(path string) *sync.Mutex {
-	actual, _ := fd.locks.LoadOrStore(path, &sync.Mutex{})
-	return actual.(*sync.Mutex)
+// purgeHandler force-drops cached entries matching the "prefix" query
+// parameter (request path prefix, e.g. POST /api/purge?prefix=/ubuntu/),
+// the "tag" query parameter (the ?tag= value a download was fetched with,
+// see serveViaDownload), or both. At least one of the two is required.
+func purgeHandler(c *gin.Context) {
+	prefix := c.Query("prefix")
+	tag := c.Query("tag")
+	if prefix == "" && tag == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "prefix or tag query parameter is required"})
+		return
+	}
+
+	var localPrefix string
+	if prefix != "" {
+		localPrefix = filepath.Join(config.StorageDir, prefix)
+	}
+	count, bytes := cacheManager.Purge(localPrefix, tag)
+
+	c.JSON(http.StatusOK, gin.H{
+		"purged_files": count,
+		"purged_bytes": bytes,
+	})
 }
 
-func (fd *FileDownloader) cleanupLock(path string) {
-	fd.locks.Delete(path)
+// acquireGroup returns the in-flight downloadGroup for path, creating and
+// registering one if none exists. The caller is the leader iff isLeader is
+// true, and is responsible for running the download in that case.
+func (fd *FileDownloader) acquireGroup(path string) (group *downloadGroup, isLeader bool) {
+	newGroup := &downloadGroup{contentLength: contentLengthPending}
+	newGroup.cond = sync.NewCond(&newGroup.mu)
+
+	actual, loaded := fd.groups.LoadOrStore(path, newGroup)
+	return actual.(*downloadGroup), !loaded
+}
+
+// releaseGroup removes group from the registry, but only if it's still the
+// current entry for path -- a fresh group may already have replaced it. This
+// identity-checked delete is what makes the group-per-path scheme
+// single-flight: a late arrival that loads the same *downloadGroup before it
+// is released joins it instead of starting a second, overlapping download.
+func (fd *FileDownloader) releaseGroup(path string, group *downloadGroup) {
+	fd.groups.CompareAndDelete(path, group)
 }
 
 func proxyHandler(c *gin.Context) {
 	requestPath := c.Request.URL.Path
 	localPath := filepath.Join(config.StorageDir, requestPath)
-	
+
 	// Check if complete file exists locally
 	if isFileComplete(localPath) {
+		if needsRedownload(localPath, requestPath) {
+			log.Printf("Cache STALE: %s, re-fetching from upstream", requestPath)
+			cacheManager.miss()
+			serveViaDownload(c, localPath, requestPath)
+			return
+		}
+
 		log.Printf("Cache HIT: %s", requestPath)
-		c.File(localPath)
+		cacheManager.recordAccess(localPath)
+		cacheManager.hit()
+		serveCached(c, localPath)
 		return
 	}
-	
-	log.Printf("Cache MISS: %s, fetching from upstream", requestPath)
-	
-	// Acquire per-file lock to prevent concurrent downloads
-	fileLock := downloader.getFileLock(localPath)
-	fileLock.Lock()
-	defer fileLock.Unlock()
-	defer downloader.cleanupLock(localPath)
-	
-	// Double-check after acquiring lock
-	if isFileComplete(localPath) {
-		log.Printf("Cache HIT (after lock): %s", requestPath)
-		c.File(localPath)
+
+	// No local copy yet: if the client only wants a range, go straight to
+	// upstream for it instead of paying for a full download first.
+	if rangeHeader := c.GetHeader("Range"); rangeHeader != "" {
+		log.Printf("Cache MISS: %s, proxying range request upstream", requestPath)
+		cacheManager.miss()
+		proxyRangeUpstream(c, requestPath, rangeHeader)
 		return
 	}
-	
-	// Download with atomic write
-	if err := downloadFile(localPath, requestPath); err != nil {
+
+	log.Printf("Cache MISS: %s, fetching from upstream", requestPath)
+	cacheManager.miss()
+	serveViaDownload(c, localPath, requestPath)
+}
+
+// serveViaDownload joins (or starts) the download group for localPath and
+// streams the result back to c. A client may override the parallel-download
+// chunk count for this request via ?concurrency=N, and tag the resulting
+// cache entry via ?tag= for later bulk purge (see purgeHandler). Both
+// overrides only take effect for the request that becomes the group's
+// leader (the one that actually starts the download); a request that joins
+// an already in-flight download has its ?concurrency= and ?tag= ignored,
+// same as today's concurrency-override behavior.
+func serveViaDownload(c *gin.Context, localPath, requestPath string) {
+	concurrency := config.ParallelChunks
+	if raw := c.Query("concurrency"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n >= 1 && n <= maxConcurrencyOverride {
+			concurrency = n
+		}
+	}
+	tag := c.Query("tag")
+
+	group, isLeader := downloader.acquireGroup(localPath)
+	if isLeader {
+		go runDownload(group, localPath, requestPath, concurrency, tag)
+	}
+
+	if err := serveFromGroup(c, group, localPath, requestPath); err != nil {
 		log.Printf("Download failed: %v", err)
 		if err == errUpstreamNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "File not found on upstream"})
 		} else {
 			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch from upstream"})
 		}
+	}
+}
+
+// serveCached serves an already-complete local file, honoring client Range
+// and conditional-request headers via http.ServeContent.
+func serveCached(c *gin.Context, localPath string) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open cached file"})
 		return
 	}
-	
-	// Verify and serve
-	if !isFileComplete(localPath) {
-		log.Printf("File verification failed: %s", requestPath)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "File verification failed"})
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to stat cached file"})
 		return
 	}
-	
-	log.Printf("Cache STORED: %s", requestPath)
-	c.File(localPath)
+
+	if checksum, cerr := os.ReadFile(localPath + ".sha256"); cerr == nil {
+		c.Header("ETag", `"`+string(checksum)+`"`)
+	}
+
+	http.ServeContent(c.Writer, c.Request, localPath, info.ModTime(), f)
 }
 
-var errUpstreamNotFound = fmt.Errorf("upstream file not found")
+// needsRedownload revalidates a cached file against upstream once it's older
+// than config.RevalidateTTL, using the ETag/Last-Modified recorded in its
+// .meta sidecar. It reports true only when upstream confirms the content has
+// actually changed (200); a 304 just refreshes the sidecar and keeps serving
+// the cached copy, and any revalidation failure fails open to the cache.
+func needsRedownload(localPath, requestPath string) bool {
+	info, err := os.Stat(localPath)
+	if err != nil || config.RevalidateTTL <= 0 || time.Since(info.ModTime()) < config.RevalidateTTL {
+		return false
+	}
 
-func downloadFile(localPath, requestPath string) error {
-	upstreamURL := config.UpstreamURL + requestPath
-	
-	// Fetch from upstream
-	resp, err := http.Get(upstreamURL)
+	meta := loadMeta(localPath)
+	if meta.ETag == "" && meta.LastModified == "" {
+		return false
+	}
+
+	req, err := http.NewRequest(http.MethodHead, config.UpstreamURL+requestPath, nil)
 	if err != nil {
-		return fmt.Errorf("fetch error: %w", err)
+		return false
+	}
+	if meta.ETag != "" {
+		req.Header.Set("If-None-Match", meta.ETag)
 	}
+	if meta.LastModified != "" {
+		req.Header.Set("If-Modified-Since", meta.LastModified)
+	}
+
+	acquireUpstreamSlot()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		releaseUpstreamSlot()
+		log.Printf("Revalidation request failed for %s, serving stale copy: %v", requestPath, err)
+		return false
+	}
+	defer releaseUpstreamSlot()
 	defer resp.Body.Close()
-	
-	if resp.StatusCode == http.StatusNotFound {
-		return errUpstreamNotFound
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode == http.StatusNotModified {
+		now := time.Now()
+		os.Chtimes(localPath, now, now)
+		meta.ETag = firstNonEmpty(resp.Header.Get("ETag"), meta.ETag)
+		meta.LastModified = firstNonEmpty(resp.Header.Get("Last-Modified"), meta.LastModified)
+		saveMeta(localPath, meta)
+		log.Printf("Revalidated (304): %s", requestPath)
+		return false
 	}
-	
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("upstream returned status %d", resp.StatusCode)
+		log.Printf("Revalidation of %s returned %d, serving cached copy", requestPath, resp.StatusCode)
+		return false
+	}
+
+	log.Printf("Revalidation found upstream change (%d) for %s, re-downloading", resp.StatusCode, requestPath)
+	return true
+}
+
+// proxyRangeUpstream forwards a client's Range request directly to upstream
+// without caching the (partial) result.
+func proxyRangeUpstream(c *gin.Context, requestPath, rangeHeader string) {
+	req, err := http.NewRequest(http.MethodGet, config.UpstreamURL+requestPath, nil)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to build upstream request"})
+		return
+	}
+	req.Header.Set("Range", rangeHeader)
+
+	acquireUpstreamSlot()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		releaseUpstreamSlot()
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch range from upstream"})
+		return
+	}
+	defer releaseUpstreamSlot()
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found on upstream"})
+		return
+	}
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("upstream returned status %d", resp.StatusCode)})
+		return
 	}
-	
-	// Create directory
+
+	for _, h := range []string{"Content-Range", "Content-Length", "Content-Type", "Accept-Ranges", "ETag", "Last-Modified"} {
+		if v := resp.Header.Get(h); v != "" {
+			c.Header(h, v)
+		}
+	}
+	c.Status(resp.StatusCode)
+	io.Copy(c.Writer, resp.Body)
+}
+
+func firstNonEmpty(a, b string) string {
+	if a != "" {
+		return a
+	}
+	return b
+}
+
+// fileMeta is the .meta sidecar recording upstream cache-validators for a
+// downloaded file, used to revalidate without a full re-download.
+type fileMeta struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+func metaPath(localPath string) string {
+	return localPath + ".meta"
+}
+
+func loadMeta(localPath string) *fileMeta {
+	data, err := os.ReadFile(metaPath(localPath))
+	if err != nil {
+		return &fileMeta{}
+	}
+	var m fileMeta
+	if err := json.Unmarshal(data, &m); err != nil {
+		return &fileMeta{}
+	}
+	return &m
+}
+
+func saveMeta(localPath string, m *fileMeta) {
+	if m.ETag == "" && m.LastModified == "" {
+		return
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(metaPath(localPath), data, 0644); err != nil {
+		log.Printf("Warning: failed to save metadata sidecar for %s: %v", localPath, err)
+	}
+}
+
+var errUpstreamNotFound = fmt.Errorf("upstream file not found")
+
+// runDownload is the leader side of a downloadGroup: it fetches requestPath
+// from upstream, streams it into tempPath while updating group.written and
+// broadcasting after every chunk, and on success renames into place. It owns
+// the group's terminal state (err/finished) and always releases the group
+// from the registry before returning.
+func runDownload(group *downloadGroup, localPath, requestPath string, concurrency int, tag string) {
+	defer downloader.releaseGroup(localPath, group)
+
+	finish := func(err error) {
+		group.mu.Lock()
+		group.err = err
+		group.finished = true
+		group.mu.Unlock()
+		group.cond.Broadcast()
+	}
+
 	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
-		return fmt.Errorf("mkdir error: %w", err)
+		finish(fmt.Errorf("mkdir error: %w", err))
+		return
 	}
-	
-	// Atomic write: download to temp file first
+
 	tempPath := localPath + ".tmp." + generateTempSuffix()
 	tempFile, err := os.Create(tempPath)
 	if err != nil {
-		return fmt.Errorf("create temp file error: %w", err)
+		finish(fmt.Errorf("create temp file error: %w", err))
+		return
 	}
-	
-	// Ensure cleanup on error
+
+	group.mu.Lock()
+	group.tempPath = tempPath
+	group.mu.Unlock()
+
 	success := false
 	defer func() {
 		tempFile.Close()
@@ -185,59 +668,614 @@ func downloadFile(localPath, requestPath string) error {
 			os.Remove(tempPath)
 		}
 	}()
-	
-	// Download with integrity check
+
+	// Large files that advertise range support are fetched over several
+	// concurrent connections instead of one; anything else falls through to
+	// the single-stream path below.
+	if length, acceptRanges, etag, lastModified, probeErr := probeUpstream(requestPath); probeErr == nil &&
+		acceptRanges && length >= config.ParallelThreshold {
+
+		group.mu.Lock()
+		group.contentLength = length
+		group.mu.Unlock()
+		group.cond.Broadcast()
+
+		if truncErr := tempFile.Truncate(length); truncErr != nil {
+			log.Printf("Failed to preallocate %s for parallel download, falling back to single-stream: %v", requestPath, truncErr)
+		} else if parAttempts, parErr := runParallelDownload(group, tempFile, requestPath, length, concurrency); parErr != nil {
+			log.Printf("Parallel download of %s failed, falling back to single-stream: %v", requestPath, parErr)
+			cacheManager.recordAttempts(int64(parAttempts))
+			tempFile.Truncate(0)
+			group.mu.Lock()
+			group.written = 0
+			group.mu.Unlock()
+		} else {
+			cacheManager.recordAttempts(int64(parAttempts))
+			checksum, hashErr := hashFile(tempFile)
+			if hashErr != nil {
+				finish(fmt.Errorf("checksum error: %w", hashErr))
+				return
+			}
+			meta := &fileMeta{ETag: etag, LastModified: lastModified}
+			if finalizeErr := finalizeDownload(tempFile, tempPath, localPath, requestPath, checksum, length, meta, tag, fmt.Sprintf(", parallel x%d", concurrency)); finalizeErr != nil {
+				finish(finalizeErr)
+				return
+			}
+			success = true
+			finish(nil)
+			return
+		}
+	}
+
 	hasher := sha256.New()
-	writer := io.MultiWriter(tempFile, hasher)
-	
-	written, err := io.Copy(writer, resp.Body)
-	if err != nil {
-		return fmt.Errorf("download error: %w", err)
+	var written int64
+	var lastResp *http.Response
+	var attempts int
+
+	for attempt := 1; attempt <= config.MaxRetries; attempt++ {
+		attempts = attempt
+
+		req, rerr := http.NewRequest(http.MethodGet, config.UpstreamURL+requestPath, nil)
+		if rerr != nil {
+			finish(fmt.Errorf("build request error: %w", rerr))
+			return
+		}
+		if written > 0 {
+			// Resume from the partial temp file; the hasher already holds
+			// the state of the bytes written so far.
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", written))
+		}
+
+		acquireUpstreamSlot()
+		resp, doErr := http.DefaultClient.Do(req)
+		if doErr != nil {
+			releaseUpstreamSlot()
+			if attempt == config.MaxRetries {
+				finish(fmt.Errorf("fetch error after %d attempts: %w", attempt, doErr))
+				return
+			}
+			log.Printf("Fetch of %s failed (attempt %d/%d), retrying: %v", requestPath, attempt, config.MaxRetries, doErr)
+			sleepBeforeRetry(attempt, 0)
+			continue
+		}
+
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			releaseUpstreamSlot()
+			finish(errUpstreamNotFound)
+			return
+		}
+
+		if isRetryableStatus(resp.StatusCode) {
+			retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+			resp.Body.Close()
+			releaseUpstreamSlot()
+			if attempt == config.MaxRetries {
+				finish(fmt.Errorf("upstream returned status %d after %d attempts", resp.StatusCode, attempt))
+				return
+			}
+			log.Printf("Upstream returned %d for %s (attempt %d/%d), retrying", resp.StatusCode, requestPath, attempt, config.MaxRetries)
+			sleepBeforeRetry(attempt, retryAfter)
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			releaseUpstreamSlot()
+			finish(fmt.Errorf("upstream returned status %d", resp.StatusCode))
+			return
+		}
+
+		if written > 0 && resp.StatusCode == http.StatusOK {
+			// We asked to resume with Range, but upstream ignored it and
+			// sent the full body back from byte 0. Restart this temp file
+			// from scratch rather than appending the full body after the
+			// partial bytes already on disk.
+			log.Printf("Upstream ignored Range for %s, restarting download from byte 0", requestPath)
+			if terr := tempFile.Truncate(0); terr != nil {
+				resp.Body.Close()
+				releaseUpstreamSlot()
+				finish(fmt.Errorf("truncate error: %w", terr))
+				return
+			}
+			if _, serr := tempFile.Seek(0, io.SeekStart); serr != nil {
+				resp.Body.Close()
+				releaseUpstreamSlot()
+				finish(fmt.Errorf("seek error: %w", serr))
+				return
+			}
+			hasher = sha256.New()
+			written = 0
+
+			group.mu.Lock()
+			group.written = 0
+			group.contentLength = contentLengthPending
+			group.mu.Unlock()
+			group.cond.Broadcast()
+		}
+
+		lastResp = resp
+
+		group.mu.Lock()
+		if group.contentLength == contentLengthPending {
+			total := resp.ContentLength
+			if resp.StatusCode == http.StatusPartialContent && total >= 0 {
+				total += written
+			}
+			group.contentLength = total
+		}
+		group.mu.Unlock()
+		group.cond.Broadcast()
+
+		copyErr := copyToGroup(tempFile, resp.Body, hasher, &written, group)
+		resp.Body.Close()
+		releaseUpstreamSlot()
+		if copyErr == nil {
+			break
+		}
+		if attempt == config.MaxRetries {
+			finish(fmt.Errorf("download error after %d attempts: %w", attempt, copyErr))
+			return
+		}
+		log.Printf("Download of %s failed mid-stream (attempt %d/%d), resuming from byte %d: %v", requestPath, attempt, config.MaxRetries, written, copyErr)
+		sleepBeforeRetry(attempt, 0)
+	}
+
+	cacheManager.recordAttempts(int64(attempts))
+
+	// Verify content length if the upstream told us one.
+	group.mu.Lock()
+	expected := group.contentLength
+	group.mu.Unlock()
+	if expected > 0 && written != expected {
+		finish(fmt.Errorf("incomplete download: got %d bytes, expected %d", written, expected))
+		return
+	}
+
+	checksum := fmt.Sprintf("%x", hasher.Sum(nil))
+	var meta *fileMeta
+	if lastResp != nil {
+		meta = &fileMeta{ETag: lastResp.Header.Get("ETag"), LastModified: lastResp.Header.Get("Last-Modified")}
 	}
-	
-	// Verify content length if provided
-	if resp.ContentLength > 0 && written != resp.ContentLength {
-		return fmt.Errorf("incomplete download: got %d bytes, expected %d", written, resp.ContentLength)
+	if err := finalizeDownload(tempFile, tempPath, localPath, requestPath, checksum, written, meta, tag, fmt.Sprintf(", attempts: %d", attempts)); err != nil {
+		finish(err)
+		return
 	}
-	
-	// Sync to disk before rename
+
+	success = true
+	finish(nil)
+}
+
+// finalizeDownload syncs, checksums-to-disk, atomically renames into place,
+// records upstream validators, and indexes the completed download. It's
+// shared by the single-stream and parallel download paths, which differ
+// only in how they arrived at checksum and size.
+func finalizeDownload(tempFile *os.File, tempPath, localPath, requestPath, checksum string, size int64, meta *fileMeta, tag, logSuffix string) error {
 	if err := tempFile.Sync(); err != nil {
 		return fmt.Errorf("sync error: %w", err)
 	}
-	
 	tempFile.Close()
-	
-	// Save checksum for integrity verification
+
 	checksumPath := localPath + ".sha256"
-	checksum := fmt.Sprintf("%x", hasher.Sum(nil))
 	if err := os.WriteFile(checksumPath, []byte(checksum), 0644); err != nil {
 		log.Printf("Warning: failed to save checksum for %s: %v", requestPath, err)
 	}
-	
-	// Atomic rename (POSIX guarantees atomicity)
+
+	// Atomic rename (POSIX guarantees atomicity); subscribers still
+	// tailing tempPath via their own open fd keep reading the same data.
 	if err := os.Rename(tempPath, localPath); err != nil {
 		return fmt.Errorf("atomic rename error: %w", err)
 	}
-	
-	success = true
-	log.Printf("Downloaded: %s (%d bytes, sha256: %s)", requestPath, written, checksum[:16])
+
+	if meta != nil {
+		saveMeta(localPath, meta)
+	}
+
+	cacheManager.registerDownload(localPath, size, tag)
+	log.Printf("Downloaded: %s (%d bytes, sha256: %s%s)", requestPath, size, checksum[:16], logSuffix)
 	return nil
 }
 
+// hashFile computes the SHA-256 of f's full contents, seeking back to the
+// start first. Used after a parallel download, where chunks are written out
+// of order and hashed as a single pass once the file is complete rather than
+// incrementally.
+func hashFile(f *os.File) (string, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+// probeUpstream issues a HEAD request to learn whether requestPath supports
+// parallel chunked downloads (a known Content-Length plus Accept-Ranges:
+// bytes), along with its cache validators.
+func probeUpstream(requestPath string) (length int64, acceptRanges bool, etag, lastModified string, err error) {
+	req, err := http.NewRequest(http.MethodHead, config.UpstreamURL+requestPath, nil)
+	if err != nil {
+		return 0, false, "", "", err
+	}
+
+	acquireUpstreamSlot()
+	resp, err := http.DefaultClient.Do(req)
+	releaseUpstreamSlot()
+	if err != nil {
+		return 0, false, "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, false, "", "", fmt.Errorf("HEAD returned status %d", resp.StatusCode)
+	}
+
+	return resp.ContentLength, resp.Header.Get("Accept-Ranges") == "bytes", resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), nil
+}
+
+// parallelState tracks, across concurrently downloading fixed-size chunks,
+// how much of the file is contiguously complete from byte 0 -- that's the
+// prefix serveFromGroup's subscribers are allowed to read.
+type parallelState struct {
+	mu             sync.Mutex
+	ends           []int64
+	done           []bool
+	nextContiguous int
+}
+
+func (ps *parallelState) markDone(chunk int) (contiguousEnd int64) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.done[chunk] = true
+	for ps.nextContiguous < len(ps.done) && ps.done[ps.nextContiguous] {
+		ps.nextContiguous++
+	}
+	if ps.nextContiguous == 0 {
+		return 0
+	}
+	return ps.ends[ps.nextContiguous-1]
+}
+
+// offsetWriter adapts os.File.WriteAt to io.Writer for a fixed starting
+// offset, so io.Copy can drive a ranged chunk download straight to disk.
+type offsetWriter struct {
+	f      *os.File
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
+
+// runParallelDownload splits [0,totalLength) into concurrency equal chunks
+// (the last absorbing any remainder) and fetches them concurrently with
+// Range requests, writing each directly to its offset in tempFile. It
+// updates group.written as the contiguous prefix from byte 0 grows, so
+// subscribers can tail the file exactly as they do for a single-stream
+// download. The final checksum is computed separately by the caller once
+// the whole file is on disk. The returned attempt count is the sum across
+// all chunks, for /api/stats's download_attempts.
+func runParallelDownload(group *downloadGroup, tempFile *os.File, requestPath string, totalLength int64, concurrency int) (int, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	chunkSize := totalLength / int64(concurrency)
+	if chunkSize == 0 {
+		chunkSize = totalLength
+		concurrency = 1
+	}
+
+	ps := &parallelState{done: make([]bool, concurrency), ends: make([]int64, concurrency)}
+	for i := 0; i < concurrency; i++ {
+		ps.ends[i] = chunkSize * int64(i+1)
+	}
+	ps.ends[concurrency-1] = totalLength
+
+	var wg sync.WaitGroup
+	errs := make([]error, concurrency)
+	attempts := make([]int, concurrency)
+	for i := 0; i < concurrency; i++ {
+		start := chunkSize * int64(i)
+		end := ps.ends[i]
+
+		wg.Add(1)
+		go func(chunk int, start, end int64) {
+			defer wg.Done()
+			attempts[chunk], errs[chunk] = fetchChunk(requestPath, start, end, tempFile, group, ps, chunk)
+		}(i, start, end)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, a := range attempts {
+		total += a
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// fetchChunk downloads [start,end) of requestPath with its own retry loop,
+// writing straight into tempFile at the matching offset. It returns the
+// number of attempts it took, whether or not it ultimately succeeded.
+func fetchChunk(requestPath string, start, end int64, tempFile *os.File, group *downloadGroup, ps *parallelState, chunk int) (int, error) {
+	var lastErr error
+	for attempt := 1; attempt <= config.MaxRetries; attempt++ {
+		req, err := http.NewRequest(http.MethodGet, config.UpstreamURL+requestPath, nil)
+		if err != nil {
+			return attempt, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+
+		acquireUpstreamSlot()
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			releaseUpstreamSlot()
+			lastErr = err
+			sleepBeforeRetry(attempt, 0)
+			continue
+		}
+
+		if resp.StatusCode != http.StatusPartialContent {
+			retryable := isRetryableStatus(resp.StatusCode)
+			retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+			resp.Body.Close()
+			releaseUpstreamSlot()
+			lastErr = fmt.Errorf("chunk %d: upstream returned status %d", chunk, resp.StatusCode)
+			if !retryable {
+				return attempt, lastErr
+			}
+			sleepBeforeRetry(attempt, retryAfter)
+			continue
+		}
+
+		w := &offsetWriter{f: tempFile, offset: start}
+		_, copyErr := io.Copy(w, resp.Body)
+		resp.Body.Close()
+		releaseUpstreamSlot()
+		if copyErr != nil {
+			lastErr = fmt.Errorf("chunk %d: %w", chunk, copyErr)
+			sleepBeforeRetry(attempt, 0)
+			continue
+		}
+
+		contiguousEnd := ps.markDone(chunk)
+		group.mu.Lock()
+		group.written = contiguousEnd
+		group.mu.Unlock()
+		group.cond.Broadcast()
+		return attempt, nil
+	}
+	return config.MaxRetries, fmt.Errorf("chunk %d failed after %d attempts: %w", chunk, config.MaxRetries, lastErr)
+}
+
+// copyToGroup streams body into f, feeding hasher and advancing *written and
+// group.written (with a broadcast) as each chunk lands.
+func copyToGroup(f *os.File, body io.Reader, hasher hash.Hash, written *int64, group *downloadGroup) error {
+	buf := make([]byte, downloadChunkSize)
+	for {
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			if _, werr := f.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			hasher.Write(buf[:n])
+			*written += int64(n)
+
+			group.mu.Lock()
+			group.written = *written
+			group.mu.Unlock()
+			group.cond.Broadcast()
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+// isRetryableStatus reports whether an upstream response status indicates a
+// transient condition worth retrying (429 or any 5xx), as opposed to a
+// permanent client error.
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || (status >= 500 && status <= 599)
+}
+
+// parseRetryAfter parses a Retry-After header (either delay-seconds or an
+// HTTP-date), returning 0 if absent or unparseable.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// sleepBeforeRetry waits with exponential backoff (full jitter, base 500ms,
+// capped at 30s) before the next attempt, honoring Retry-After when it calls
+// for a longer wait.
+func sleepBeforeRetry(attempt int, retryAfter time.Duration) {
+	delay := backoffDelay(attempt)
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	time.Sleep(delay)
+}
+
+func backoffDelay(attempt int) time.Duration {
+	delayCap := retryBaseDelay * time.Duration(int64(1)<<uint(attempt-1))
+	if delayCap <= 0 || delayCap > retryMaxDelay {
+		delayCap = retryMaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(delayCap)))
+}
+
+// serveFromGroup streams the result of group to c as it becomes available.
+// It reads group.tempPath from the last offset it has sent up through
+// group.written each time it wakes, so a request that attaches after bytes
+// are already on disk immediately catches up on the completed prefix before
+// following the live tail. If the client can't keep up with a chunk within
+// subscriberWriteTimeout, this subscriber is dropped without affecting the
+// leader or any other subscriber.
+func serveFromGroup(c *gin.Context, group *downloadGroup, localPath, requestPath string) error {
+	group.mu.Lock()
+	for group.contentLength == contentLengthPending && !group.finished {
+		group.cond.Wait()
+	}
+	contentLength := group.contentLength
+	tempPath := group.tempPath
+	finished := group.finished
+	err := group.err
+	group.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	// If the download already finished successfully by the time we got
+	// here, runDownload may have already renamed tempPath to localPath
+	// (finish(nil) runs after the rename), so tempPath itself may no
+	// longer exist. Read the finished file directly rather than racing
+	// the rename.
+	openPath := tempPath
+	if finished {
+		openPath = localPath
+	}
+
+	f, openErr := os.Open(openPath)
+	if openErr != nil && os.IsNotExist(openErr) && openPath != localPath {
+		// finalizeDownload renames tempPath to localPath before runDownload
+		// calls finish(nil), so there's a window where the rename has
+		// already happened but group.finished is still false. Fall back to
+		// localPath rather than failing a request that attached in that
+		// window.
+		f, openErr = os.Open(localPath)
+	}
+	if openErr != nil {
+		return fmt.Errorf("open temp file error: %w", openErr)
+	}
+	defer f.Close()
+
+	rc := http.NewResponseController(c.Writer)
+	headersSent := false
+	var offset int64
+
+	for {
+		group.mu.Lock()
+		for group.written <= offset && !group.finished {
+			group.cond.Wait()
+		}
+		target := group.written
+		finished := group.finished
+		err := group.err
+		group.mu.Unlock()
+
+		if !headersSent {
+			c.Header("Content-Type", contentTypeFor(localPath))
+			if contentLength >= 0 {
+				c.Header("Content-Length", strconv.FormatInt(contentLength, 10))
+			}
+			c.Status(http.StatusOK)
+			headersSent = true
+		}
+
+		if target > offset {
+			rc.SetWriteDeadline(time.Now().Add(subscriberWriteTimeout))
+			if _, werr := io.Copy(c.Writer, io.NewSectionReader(f, offset, target-offset)); werr != nil {
+				log.Printf("Dropping slow subscriber for %s: %v", requestPath, werr)
+				return nil
+			}
+			rc.SetWriteDeadline(time.Time{})
+			rc.Flush()
+			offset = target
+		}
+
+		if err != nil {
+			if offset == 0 {
+				return err
+			}
+			log.Printf("Download for %s failed mid-stream for a subscriber: %v", requestPath, err)
+			return nil
+		}
+		if finished && offset >= target {
+			return nil
+		}
+	}
+}
+
+func contentTypeFor(path string) string {
+	if ctype := mime.TypeByExtension(filepath.Ext(path)); ctype != "" {
+		return ctype
+	}
+	return "application/octet-stream"
+}
+
+// startTempFileReaper sweeps storageDir once immediately and then every
+// interval, removing *.tmp.* files older than maxAge. A download in
+// progress keeps writing to its temp file, so its mtime stays recent and it
+// is never reaped out from under the live downloadGroup; only temp files
+// left behind by a crashed or killed process are old enough to be swept.
+func startTempFileReaper(storageDir string, maxAge, interval time.Duration) {
+	reapStaleTempFiles(storageDir, maxAge)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			reapStaleTempFiles(storageDir, maxAge)
+		}
+	}()
+}
+
+func reapStaleTempFiles(storageDir string, maxAge time.Duration) {
+	now := time.Now()
+	filepath.Walk(storageDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !isTempFile(path) {
+			return nil
+		}
+		if now.Sub(info.ModTime()) < maxAge {
+			return nil
+		}
+		if rmErr := os.Remove(path); rmErr != nil {
+			log.Printf("Warning: failed to reap stale temp file %s: %v", path, rmErr)
+		} else {
+			log.Printf("Reaped stale temp file: %s", path)
+		}
+		return nil
+	})
+}
+
 func isFileComplete(path string) bool {
 	// Check if file exists and is not empty
 	fileInfo, err := os.Stat(path)
 	if err != nil || fileInfo.IsDir() || fileInfo.Size() == 0 {
 		return false
 	}
-	
+
 	// Check if temp files exist (indicates incomplete download)
 	pattern := path + ".tmp.*"
 	matches, _ := filepath.Glob(pattern)
 	if len(matches) > 0 {
 		return false
 	}
-	
+
 	// Verify checksum if available
 	checksumPath := path + ".sha256"
 	if checksumData, err := os.ReadFile(checksumPath); err == nil {
@@ -249,7 +1287,7 @@ func isFileComplete(path string) bool {
 			return false
 		}
 	}
-	
+
 	return true
 }
 
@@ -259,12 +1297,12 @@ func calculateChecksum(path string) string {
 		return ""
 	}
 	defer file.Close()
-	
+
 	hasher := sha256.New()
 	if _, err := io.Copy(hasher, file); err != nil {
 		return ""
 	}
-	
+
 	return fmt.Sprintf("%x", hasher.Sum(nil))
 }
 
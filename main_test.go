@@ -0,0 +1,484 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestEvictIfNeededLowWaterBoundary checks that eviction stops as soon as
+// total usage drops to cacheLowWaterRatio of the limit, evicting
+// least-recently-used entries first and leaving the most recently used ones
+// in place.
+func TestEvictIfNeededLowWaterBoundary(t *testing.T) {
+	cm := newCacheManager(0)
+	cm.limitBytes = 1000
+	lowWater := int64(float64(cm.limitBytes) * cacheLowWaterRatio)
+
+	base := time.Unix(1700000000, 0)
+	entries := []struct {
+		path string
+		size int64
+		age  time.Duration
+	}{
+		{"/a", 300, 4 * time.Minute}, // oldest
+		{"/b", 300, 3 * time.Minute},
+		{"/c", 300, 2 * time.Minute},
+		{"/d", 300, 1 * time.Minute}, // newest
+	}
+	for _, e := range entries {
+		cm.entries[e.path] = &cacheEntry{size: e.size, lastAccess: base.Add(-e.age)}
+		cm.totalSize += e.size
+	}
+	if cm.totalSize <= cm.limitBytes {
+		t.Fatalf("test setup: totalSize %d must exceed limitBytes %d", cm.totalSize, cm.limitBytes)
+	}
+
+	cm.evictIfNeeded()
+
+	if cm.totalSize > lowWater {
+		t.Fatalf("totalSize %d still above low-water mark %d after eviction", cm.totalSize, lowWater)
+	}
+	if _, ok := cm.entries["/a"]; ok {
+		t.Error("oldest entry /a should have been evicted")
+	}
+	if _, ok := cm.entries["/d"]; !ok {
+		t.Error("newest entry /d should not have been evicted")
+	}
+	if cm.evictions == 0 {
+		t.Error("evictions counter should have been incremented")
+	}
+}
+
+// TestEvictIfNeededNoopUnderLimit checks that eviction is a no-op when usage
+// is already at or below the configured limit.
+func TestEvictIfNeededNoopUnderLimit(t *testing.T) {
+	cm := newCacheManager(0)
+	cm.limitBytes = 1000
+	cm.entries["/a"] = &cacheEntry{size: 500, lastAccess: time.Unix(1700000000, 0)}
+	cm.totalSize = 500
+
+	cm.evictIfNeeded()
+
+	if _, ok := cm.entries["/a"]; !ok {
+		t.Fatal("entry should not have been evicted while under the limit")
+	}
+	if cm.evictions != 0 {
+		t.Fatalf("evictions = %d, want 0", cm.evictions)
+	}
+}
+
+// TestHasPathPrefix checks that hasPathPrefix treats prefix as a directory
+// boundary rather than a raw string prefix, so "/ubuntu" doesn't also match
+// a sibling like "/ubuntu-25.10/...".
+func TestHasPathPrefix(t *testing.T) {
+	tests := []struct {
+		path   string
+		prefix string
+		want   bool
+	}{
+		{"/ubuntu", "/ubuntu", true},
+		{"/ubuntu/22.04/iso", "/ubuntu", true},
+		{"/ubuntu-25.10/iso", "/ubuntu", false},
+		{"/ubuntu25.10", "/ubuntu", false},
+		{"/debian/iso", "/ubuntu", false},
+	}
+	for _, tt := range tests {
+		if got := hasPathPrefix(tt.path, tt.prefix); got != tt.want {
+			t.Errorf("hasPathPrefix(%q, %q) = %v, want %v", tt.path, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+// TestPurgeByPrefix checks that Purge removes entries under a path prefix
+// without touching a sibling path that merely extends the prefix string.
+func TestPurgeByPrefix(t *testing.T) {
+	dir := t.TempDir()
+	cm := newCacheManager(0)
+
+	matching := filepath.Join(dir, "ubuntu", "22.04", "iso")
+	sibling := filepath.Join(dir, "ubuntu-25.10", "iso")
+	for _, p := range []string{matching, sibling} {
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte("data"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", p, err)
+		}
+		cm.entries[p] = &cacheEntry{size: 4}
+		cm.totalSize += 4
+	}
+
+	n, bytes := cm.Purge(filepath.Join(dir, "ubuntu"), "")
+
+	if n != 1 || bytes != 4 {
+		t.Fatalf("Purge returned (%d, %d), want (1, 4)", n, bytes)
+	}
+	if _, ok := cm.entries[matching]; ok {
+		t.Error("matching entry should have been purged")
+	}
+	if _, ok := cm.entries[sibling]; !ok {
+		t.Error("sibling entry should not have been purged")
+	}
+	if _, err := os.Stat(matching); !os.IsNotExist(err) {
+		t.Error("matching file should have been removed from disk")
+	}
+	if _, err := os.Stat(sibling); err != nil {
+		t.Error("sibling file should still exist on disk")
+	}
+}
+
+// TestPurgeByTag checks that Purge also drops entries by tag, independent of
+// path prefix, and that an untagged entry under a different prefix survives.
+func TestPurgeByTag(t *testing.T) {
+	dir := t.TempDir()
+	cm := newCacheManager(0)
+
+	tagged := filepath.Join(dir, "release", "image.iso")
+	untagged := filepath.Join(dir, "other", "file.bin")
+	for _, p := range []string{tagged, untagged} {
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte("data"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", p, err)
+		}
+	}
+	cm.entries[tagged] = &cacheEntry{size: 4, tag: "nightly"}
+	cm.entries[untagged] = &cacheEntry{size: 4}
+	cm.totalSize = 8
+
+	n, bytes := cm.Purge("", "nightly")
+
+	if n != 1 || bytes != 4 {
+		t.Fatalf("Purge returned (%d, %d), want (1, 4)", n, bytes)
+	}
+	if _, ok := cm.entries[tagged]; ok {
+		t.Error("tagged entry should have been purged")
+	}
+	if _, ok := cm.entries[untagged]; !ok {
+		t.Error("untagged entry should not have been purged")
+	}
+}
+
+// TestPurgeByPrefixOrTag checks that prefix and tag are ORed together: an
+// entry matching either is purged, not only one matching both.
+func TestPurgeByPrefixOrTag(t *testing.T) {
+	dir := t.TempDir()
+	cm := newCacheManager(0)
+
+	byPrefix := filepath.Join(dir, "ubuntu", "iso")
+	byTag := filepath.Join(dir, "other", "file.bin")
+	neither := filepath.Join(dir, "debian", "iso")
+	for _, p := range []string{byPrefix, byTag, neither} {
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte("data"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", p, err)
+		}
+	}
+	cm.entries[byPrefix] = &cacheEntry{size: 4}
+	cm.entries[byTag] = &cacheEntry{size: 4, tag: "nightly"}
+	cm.entries[neither] = &cacheEntry{size: 4}
+	cm.totalSize = 12
+
+	n, _ := cm.Purge(filepath.Join(dir, "ubuntu"), "nightly")
+
+	if n != 2 {
+		t.Fatalf("Purge removed %d entries, want 2", n)
+	}
+	if _, ok := cm.entries[neither]; !ok {
+		t.Error("entry matching neither prefix nor tag should not have been purged")
+	}
+}
+
+// TestServeFromGroupLateJoinerAfterRename exercises the window finalizeDownload
+// opens between renaming tempPath to localPath and runDownload calling
+// finish(nil): a subscriber that attaches in that window must still be able
+// to read the completed file from localPath instead of failing because
+// tempPath is already gone.
+func TestServeFromGroupLateJoinerAfterRename(t *testing.T) {
+	dir := t.TempDir()
+	tempPath := filepath.Join(dir, "file.tmp")
+	localPath := filepath.Join(dir, "file")
+
+	content := []byte("the quick brown fox jumps over the lazy dog")
+	if err := os.WriteFile(tempPath, content, 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+
+	group := &downloadGroup{
+		contentLength: int64(len(content)),
+		written:       int64(len(content)),
+		tempPath:      tempPath,
+	}
+	group.cond = sync.NewCond(&group.mu)
+
+	// Mirror finalizeDownload: the rename to localPath happens before
+	// runDownload marks the group finished, so a joiner can observe
+	// contentLength/written already final but group.finished still false.
+	if err := os.Rename(tempPath, localPath); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+
+	// Mirror finish(nil) being called shortly after the rename, the way
+	// runDownload does once finalizeDownload returns.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		group.mu.Lock()
+		group.finished = true
+		group.mu.Unlock()
+		group.cond.Broadcast()
+	}()
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/file", nil)
+
+	done := make(chan error, 1)
+	go func() { done <- serveFromGroup(c, group, localPath, "/file") }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("serveFromGroup returned error for late joiner after rename: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("serveFromGroup did not return within timeout")
+	}
+	if got := w.Body.String(); got != string(content) {
+		t.Fatalf("serveFromGroup wrote %q, want %q", got, content)
+	}
+}
+
+// TestServeFromGroupLateJoinerAfterFinish covers the simpler case where the
+// group is already marked finished by the time a subscriber attaches.
+func TestServeFromGroupLateJoinerAfterFinish(t *testing.T) {
+	dir := t.TempDir()
+	localPath := filepath.Join(dir, "file")
+
+	content := []byte("finished before anyone subscribed")
+	if err := os.WriteFile(localPath, content, 0o644); err != nil {
+		t.Fatalf("write local file: %v", err)
+	}
+
+	group := &downloadGroup{
+		contentLength: int64(len(content)),
+		written:       int64(len(content)),
+		finished:      true,
+	}
+	group.cond = sync.NewCond(&group.mu)
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/file", nil)
+
+	if err := serveFromGroup(c, group, localPath, "/file"); err != nil {
+		t.Fatalf("serveFromGroup returned error for finished group: %v", err)
+	}
+	if got := w.Body.String(); got != string(content) {
+		t.Fatalf("serveFromGroup wrote %q, want %q", got, content)
+	}
+}
+
+// TestBackoffDelay checks that the jittered delay always lands in
+// [0, cap), and that the cap itself grows with attempt and clamps at
+// retryMaxDelay.
+func TestBackoffDelay(t *testing.T) {
+	cases := []struct {
+		attempt int
+		wantCap time.Duration
+	}{
+		{1, retryBaseDelay},     // 500ms * 2^0
+		{2, 2 * retryBaseDelay}, // 500ms * 2^1
+		{4, 8 * retryBaseDelay}, // 500ms * 2^3 = 4s
+		{10, retryMaxDelay},     // 500ms * 2^9 overflows the 30s cap
+	}
+	for _, tc := range cases {
+		for i := 0; i < 20; i++ {
+			got := backoffDelay(tc.attempt)
+			if got < 0 || got >= tc.wantCap {
+				t.Fatalf("backoffDelay(%d) = %v, want in [0, %v)", tc.attempt, got, tc.wantCap)
+			}
+		}
+	}
+}
+
+// TestParseRetryAfter covers the delay-seconds form, the HTTP-date form, and
+// the absent/unparseable fallback to 0.
+func TestParseRetryAfter(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+
+	cases := []struct {
+		name  string
+		value string
+		check func(t *testing.T, got time.Duration)
+	}{
+		{
+			name:  "empty",
+			value: "",
+			check: func(t *testing.T, got time.Duration) {
+				if got != 0 {
+					t.Errorf("got %v, want 0", got)
+				}
+			},
+		},
+		{
+			name:  "delay-seconds",
+			value: "120",
+			check: func(t *testing.T, got time.Duration) {
+				if got != 120*time.Second {
+					t.Errorf("got %v, want 120s", got)
+				}
+			},
+		},
+		{
+			name:  "unparseable",
+			value: "not-a-duration",
+			check: func(t *testing.T, got time.Duration) {
+				if got != 0 {
+					t.Errorf("got %v, want 0", got)
+				}
+			},
+		},
+		{
+			name:  "http-date in the future",
+			value: future.UTC().Format(http.TimeFormat),
+			check: func(t *testing.T, got time.Duration) {
+				if got <= 0 || got > time.Hour {
+					t.Errorf("got %v, want a positive duration up to 1h", got)
+				}
+			},
+		},
+		{
+			name:  "http-date in the past",
+			value: time.Unix(0, 0).UTC().Format(http.TimeFormat),
+			check: func(t *testing.T, got time.Duration) {
+				if got != 0 {
+					t.Errorf("got %v, want 0 for a past date", got)
+				}
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.check(t, parseRetryAfter(tc.value))
+		})
+	}
+}
+
+// withUpstream points config.UpstreamURL at srv for the duration of the test
+// and restores it (along with config.MaxRetries and upstreamSemaphore,
+// which runParallelDownload/fetchChunk also depend on) afterward.
+func withUpstream(t *testing.T, srv *httptest.Server, maxRetries int) {
+	t.Helper()
+	prevURL := config.UpstreamURL
+	prevRetries := config.MaxRetries
+	prevSem := upstreamSemaphore
+	config.UpstreamURL = srv.URL
+	config.MaxRetries = maxRetries
+	upstreamSemaphore = make(chan struct{}, 16)
+	t.Cleanup(func() {
+		config.UpstreamURL = prevURL
+		config.MaxRetries = prevRetries
+		upstreamSemaphore = prevSem
+	})
+}
+
+func rangeServer(t *testing.T, data []byte, failChunkStart int64) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start, end int64
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil {
+			http.Error(w, "bad range", http.StatusBadRequest)
+			return
+		}
+		if start == failChunkStart {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[start : end+1])
+	}))
+}
+
+// TestRunParallelDownloadSuccess exercises the normal split/combine path:
+// every chunk's Range request succeeds and the reassembled tempFile matches
+// the source data exactly.
+func TestRunParallelDownloadSuccess(t *testing.T) {
+	data := make([]byte, 4096)
+	for i := range data {
+		data[i] = byte(i % 256)
+	}
+	srv := rangeServer(t, data, -1)
+	defer srv.Close()
+	withUpstream(t, srv, 3)
+
+	tempFile, err := os.CreateTemp(t.TempDir(), "parallel-*.tmp")
+	if err != nil {
+		t.Fatalf("create temp file: %v", err)
+	}
+	defer tempFile.Close()
+	if err := tempFile.Truncate(int64(len(data))); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+
+	group := &downloadGroup{contentLength: int64(len(data))}
+	group.cond = sync.NewCond(&group.mu)
+
+	attempts, err := runParallelDownload(group, tempFile, "/file", int64(len(data)), 4)
+	if err != nil {
+		t.Fatalf("runParallelDownload: %v", err)
+	}
+	if attempts != 4 {
+		t.Errorf("attempts = %d, want 4 (one per chunk, no retries)", attempts)
+	}
+
+	got, err := os.ReadFile(tempFile.Name())
+	if err != nil {
+		t.Fatalf("read temp file: %v", err)
+	}
+	if string(got) != string(data) {
+		t.Error("reassembled temp file does not match source data")
+	}
+}
+
+// TestRunParallelDownloadFallback covers the condition that makes runDownload
+// give up on the parallel path and fall back to single-stream: one chunk's
+// Range request fails on every attempt, so runParallelDownload exhausts
+// config.MaxRetries for that chunk and returns an error.
+func TestRunParallelDownloadFallback(t *testing.T) {
+	data := make([]byte, 4096)
+	chunkSize := int64(len(data)) / 4
+	srv := rangeServer(t, data, chunkSize) // chunk 1 always fails
+	defer srv.Close()
+	withUpstream(t, srv, 2)
+
+	tempFile, err := os.CreateTemp(t.TempDir(), "parallel-*.tmp")
+	if err != nil {
+		t.Fatalf("create temp file: %v", err)
+	}
+	defer tempFile.Close()
+	if err := tempFile.Truncate(int64(len(data))); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+
+	group := &downloadGroup{contentLength: int64(len(data))}
+	group.cond = sync.NewCond(&group.mu)
+
+	if _, err := runParallelDownload(group, tempFile, "/file", int64(len(data)), 4); err == nil {
+		t.Fatal("expected runParallelDownload to return an error for the permanently failing chunk")
+	}
+}